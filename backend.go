@@ -0,0 +1,24 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"github.com/coreos/go-etcd/etcd"
+	"strings"
+)
+
+// Backend mirrors a vulcand backend entry: the shared configuration for a
+// group of servers fronting the same service.
+type Backend struct {
+	Type string `json:"Type"`
+}
+
+func (b *Backend) put(client *etcd.Client, basepath string, svcKey string) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Join([]string{basepath, "backends", svcKey, "backend"}, "/")
+	_, err = client.Set(key, string(body), 0)
+	return err
+}
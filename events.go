@@ -0,0 +1,119 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"strings"
+)
+
+// handleContainerEvent re-evaluates a single container against the service
+// regex list (or, under --label-discovery, by its labels) in response to a
+// docker lifecycle event, registering or
+// deregistering it with vulcand as appropriate. This keeps the routing
+// table current between ticker sweeps.
+func handleContainerEvent(dclient *docker.Client, registry ServiceRegistry, svcs services, event *docker.APIEvents) {
+	switch {
+	case event.Status == "start" || event.Status == "health_status: healthy":
+		reevaluateContainer(dclient, registry, svcs, event.ID)
+	case event.Status == "die" || event.Status == "destroy" || event.Status == "health_status: unhealthy":
+		if opts.LabelDiscovery {
+			deregisterLabeledContainerEvent(registry, opts.LabelPrefix, event)
+			return
+		}
+
+		cleanName := containerNameFromEvent(event)
+		if cleanName == "" {
+			log.WithField("container", event.ID).Debug("event carried no container name, skipping deregistration")
+			return
+		}
+
+		for _, s := range svcs {
+			if s.re.MatchString(cleanName) {
+				log.WithField("container_name", cleanName).Debug("deregistering container as server")
+				if err := registry.DeleteServer(s.key, cleanName); err != nil {
+					log.WithFields(log.Fields{
+						"error":          err,
+						"service":        s.key,
+						"container_name": cleanName,
+					}).Warn("could not remove container from server registry")
+				}
+			}
+		}
+	}
+}
+
+// deregisterLabeledContainerEvent removes a labeled container's server
+// entry using the service name and instance name carried on the event
+// itself, without needing a live container inspect (the container is
+// already gone by the time a die/destroy event is handled).
+func deregisterLabeledContainerEvent(registry ServiceRegistry, prefix string, event *docker.APIEvents) {
+	if event.Actor.Attributes == nil {
+		return
+	}
+
+	svcName, ok := event.Actor.Attributes[prefix+".service"]
+	if !ok || svcName == "" {
+		return
+	}
+
+	cleanName := strings.TrimLeft(event.Actor.Attributes["name"], "/")
+	if cleanName == "" {
+		log.WithField("container", event.ID).Debug("event carried no container name, skipping deregistration")
+		return
+	}
+
+	log.WithField("container_name", cleanName).Debug("deregistering labeled container as server")
+	if err := registry.DeleteServer(svcName, cleanName); err != nil {
+		log.WithFields(log.Fields{
+			"error":          err,
+			"service":        svcName,
+			"container_name": cleanName,
+		}).Warn("could not remove container from server registry")
+		return
+	}
+
+	reapLabeledService(registry, svcName)
+}
+
+// reevaluateContainer looks up a single container by ID and registers it
+// with vulcand if it matches a known service.
+func reevaluateContainer(dclient *docker.Client, registry ServiceRegistry, svcs services, id string) {
+	containers, err := dclient.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"id": {id}},
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err,
+			"container": id,
+		}).Warn("unable to inspect container for event")
+		return
+	}
+
+	for _, c := range containers {
+		if opts.LabelDiscovery {
+			registerLabeledContainer(registry, opts.LabelPrefix, &c)
+			continue
+		}
+
+		for _, name := range c.Names {
+			cleanName := strings.TrimLeft(name, "/")
+			for _, s := range svcs {
+				if s.re.MatchString(cleanName) {
+					log.WithField("container_name", cleanName).Debug("registering container as server (event)")
+					registerContainerWithVulcan(registry, s, &c, cleanName)
+				}
+			}
+		}
+	}
+}
+
+// containerNameFromEvent pulls the container name out of a docker event's
+// actor attributes. Returns "" if the event didn't carry one (e.g. an older
+// docker daemon).
+func containerNameFromEvent(event *docker.APIEvents) string {
+	if event.Actor.Attributes == nil {
+		return ""
+	}
+	return strings.TrimLeft(event.Actor.Attributes["name"], "/")
+}
@@ -0,0 +1,85 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coreos/go-etcd/etcd"
+	"strings"
+)
+
+// Frontend mirrors a vulcand frontend entry: a route rule bound to a
+// backend. desoto emits one frontend per service, matching on the
+// configured external hostname and a path prefix derived from the
+// service's key.
+type Frontend struct {
+	Type      string `json:"Type"`
+	BackendId string `json:"BackendId"`
+	Route     string `json:"Route"`
+}
+
+func (f *Frontend) put(client *etcd.Client, basepath string, frontendID string) error {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Join([]string{basepath, "frontends", frontendID, "frontend"}, "/")
+	_, err = client.Set(key, string(body), 0)
+	return err
+}
+
+// Middleware mirrors a vulcand middleware entry attached to a frontend,
+// e.g. a rewrite or auth handler that runs before the request reaches the
+// backend.
+type Middleware struct {
+	Type     string `json:"Type"`
+	ID       string `json:"Id"`
+	Priority int    `json:"Priority"`
+}
+
+func (m *Middleware) put(client *etcd.Client, basepath string, frontendID string) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Join([]string{basepath, "frontends", frontendID, "middlewares", m.ID}, "/")
+	_, err = client.Set(key, string(body), 0)
+	return err
+}
+
+// KeyPair is a TLS certificate and private key, PEM encoded.
+type KeyPair struct {
+	Cert []byte `json:"Cert"`
+	Key  []byte `json:"Key"`
+}
+
+// HostSettings mirrors vulcand's per-host configuration, used here solely
+// to carry the TLS listener's certificate.
+type HostSettings struct {
+	KeyPair *KeyPair `json:"KeyPair"`
+}
+
+func (h *HostSettings) put(client *etcd.Client, basepath string, host string) error {
+	body, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Join([]string{basepath, "hosts", host, "host"}, "/")
+	_, err = client.Set(key, string(body), 0)
+	return err
+}
+
+func frontendRoute(host, svcKey string, methods []string) string {
+	route := fmt.Sprintf(`Host("%s") && PathRegexp("^/%s")`, host, strings.Trim(svcKey, "/"))
+	if len(methods) == 0 {
+		return route
+	}
+
+	quoted := make([]string, len(methods))
+	for i, m := range methods {
+		quoted[i] = fmt.Sprintf("%q", m)
+	}
+	return fmt.Sprintf(`%s && Method(%s)`, route, strings.Join(quoted, ", "))
+}
@@ -0,0 +1,148 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"strconv"
+	"strings"
+)
+
+// registerLabeledContainer discovers a container's service membership from
+// its labels instead of matching its name against a pre-registered regex
+// service definition. A container must carry "<prefix>.service" and
+// "<prefix>.port" labels to be considered. An optional "<prefix>.middleware"
+// label (comma-separated vulcand middleware types, in order) drives that
+// service's frontend middleware chain when --manage-frontends is set.
+func registerLabeledContainer(registry ServiceRegistry, prefix string, container *docker.APIContainers) {
+	svcName, ok := container.Labels[prefix+".service"]
+	if !ok || svcName == "" {
+		return
+	}
+
+	portLabel, ok := container.Labels[prefix+".port"]
+	if !ok {
+		log.WithField("container", container.ID).Warn("labeled container is missing a port label, skipping")
+		return
+	}
+
+	containerPort, err := strconv.ParseInt(portLabel, 10, 64)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err,
+			"container": container.ID,
+			"port":      portLabel,
+		}).Warn("labeled container has an invalid port label, skipping")
+		return
+	}
+
+	middleware := splitLabelList(container.Labels[prefix+".middleware"])
+
+	cleanName := strings.TrimLeft(firstOrEmpty(container.Names), "/")
+	svc, err := newLabeledService(svcName, cleanName, containerPort, middleware)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err,
+			"container": container.ID,
+			"service":   svcName,
+		}).Warn("could not synthesize service definition from labels")
+		return
+	}
+
+	if err := registry.PutBackend(svc); err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"service": svc.key,
+		}).Warn("could not register backend")
+	}
+
+	if opts.ManageFrontends {
+		if err := registry.PutFrontend(svc, opts.FrontendMiddleware); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"service": svc.key,
+			}).Warn("could not register frontend")
+		}
+	}
+
+	registerContainerWithVulcan(registry, svc, container, cleanName)
+}
+
+// splitLabelList splits a comma-separated label value into a trimmed,
+// non-empty slice. An empty input yields a nil slice.
+func splitLabelList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func firstOrEmpty(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// newLabeledService synthesizes a Service that matches exactly one
+// container by name, carrying the port and middleware chain declared via
+// labels rather than a pre-registered service definition.
+func newLabeledService(svcName, containerName string, containerPort int64, middleware []string) (*Service, error) {
+	def, err := json.Marshal(map[string]interface{}{
+		"ContainerPort": containerPort,
+		"Name":          fmt.Sprintf("^%s$", containerName),
+		"Middleware":    middleware,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newService("/"+svcName, def)
+}
+
+// reapLabeledService removes a label-discovered service's backend (and
+// frontend, if one was written) once its last server instance has been
+// deregistered. Without this, every container restart under
+// --label-discovery would leak a dead backend+frontend pair into
+// vulcand's etcd tree, since label-discovered services never appear in
+// the pre-registered svcs list reapOrphans otherwise sweeps.
+func reapLabeledService(registry ServiceRegistry, svcKey string) {
+	instances, err := registry.ListServers(svcKey)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"service": svcKey,
+		}).Warn("unable to list servers while reaping labeled service")
+		return
+	}
+
+	if len(instances) > 0 {
+		return
+	}
+
+	log.WithField("service", svcKey).Info("reaping orphaned labeled backend")
+	if err := registry.DeleteBackend(svcKey); err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"service": svcKey,
+		}).Warn("could not remove orphaned backend")
+	}
+
+	if opts.ManageFrontends {
+		if err := registry.DeleteFrontend(svcKey); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"service": svcKey,
+			}).Warn("could not remove orphaned frontend")
+		}
+	}
+}
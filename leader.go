@@ -0,0 +1,108 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/coreos/go-etcd/etcd"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// leaderKey is the etcd key used as the election lock. A single desoto
+// cluster (identified by the etcd hosts it shares) has exactly one leader.
+const leaderKey = "/desoto/leader"
+
+// leaderTTL is how long an acquired lease is valid for before it must be
+// renewed. If the leader dies without renewing, the lease expires and a
+// follower can take over.
+const leaderTTL = uint64(10)
+
+// LeaderElector coordinates which desoto instance in a cluster is allowed
+// to perform reconciliation writes against vulcand, using a TTL'd etcd key
+// as a lock. Followers keep running their watches so they can take over the
+// instant the lease lapses.
+type LeaderElector struct {
+	client *etcd.Client
+	nodeID string
+
+	mu     sync.RWMutex
+	leader bool
+}
+
+func newLeaderElector(client *etcd.Client, nodeID string) *LeaderElector {
+	return &LeaderElector{client: client, nodeID: nodeID}
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (le *LeaderElector) IsLeader() bool {
+	le.mu.RLock()
+	defer le.mu.RUnlock()
+	return le.leader
+}
+
+// run repeatedly renews or re-attempts the leader lease until the process
+// exits. Call tryAcquireOrRenew once synchronously before starting this in
+// its own goroutine, so callers reading IsLeader() right after don't race
+// the first attempt.
+func (le *LeaderElector) run() {
+	ticker := time.NewTicker(time.Duration(leaderTTL/2) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		le.tryAcquireOrRenew()
+	}
+}
+
+func (le *LeaderElector) tryAcquireOrRenew() {
+	if le.IsLeader() {
+		if _, err := le.client.CompareAndSwap(leaderKey, le.nodeID, leaderTTL, le.nodeID, 0); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"node_id": le.nodeID,
+			}).Warn("failed to renew leader lease, stepping down")
+			le.setLeader(false)
+		}
+		return
+	}
+
+	if _, err := le.client.Create(leaderKey, le.nodeID, leaderTTL); err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"node_id": le.nodeID,
+		}).Debug("leader lease held by another node")
+		le.setLeader(false)
+		return
+	}
+
+	log.WithField("node_id", le.nodeID).Info("acquired leader lease")
+	le.setLeader(true)
+}
+
+func (le *LeaderElector) setLeader(leader bool) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.leader != leader {
+		log.WithFields(log.Fields{
+			"node_id": le.nodeID,
+			"leader":  leader,
+		}).Info("leader state changed")
+	}
+	le.leader = leader
+}
+
+// statusHandler reports this instance's node ID and leader/follower state.
+func (le *LeaderElector) statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		NodeID string `json:"node_id"`
+		Leader bool   `json:"leader"`
+	}{
+		NodeID: le.nodeID,
+		Leader: le.IsLeader(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.WithField("error", err).Warn("could not encode status response")
+	}
+}
@@ -0,0 +1,24 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import "testing"
+
+func TestLeaderElectorIsLeaderDefaultsFalse(t *testing.T) {
+	le := newLeaderElector(nil, "node-a")
+	if le.IsLeader() {
+		t.Fatal("expected a freshly constructed LeaderElector to not be leader")
+	}
+}
+
+func TestLeaderElectorSetLeaderTransitions(t *testing.T) {
+	le := newLeaderElector(nil, "node-a")
+
+	le.setLeader(true)
+	if !le.IsLeader() {
+		t.Fatal("expected IsLeader to be true after setLeader(true)")
+	}
+
+	le.setLeader(false)
+	if le.IsLeader() {
+		t.Fatal("expected IsLeader to be false after setLeader(false)")
+	}
+}
@@ -3,13 +3,15 @@ package main // import "github.com/christian-blades-cb/desoto"
 import (
 	"errors"
 	log "github.com/Sirupsen/logrus"
-	"github.com/cenkalti/backoff"
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/jessevdk/go-flags"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -23,6 +25,23 @@ var opts struct {
 	DockerPath string `short:"d" long:"docker-host" env:"DOCKER_HOST" description:"docker path" default:"unix:///var/run/docker.sock"`
 
 	Host string `short:"h" long:"hostname" env:"HOST" description:"external hostname, used for registering application to vulcand (in order to be useful, this hostname must be routable from vulcand)" default:"localhost"`
+
+	HA     bool   `long:"ha" env:"DESOTO_HA" description:"enable leader election so only one desoto instance per cluster writes to vulcand"`
+	NodeID string `long:"node-id" env:"DESOTO_NODE_ID" description:"unique identifier for this instance, used for leader election (defaults to hostname)"`
+
+	Registry       string `long:"registry" env:"DESOTO_REGISTRY" description:"service-discovery backend to read definitions from" choice:"etcd" choice:"consul" choice:"file" default:"etcd"`
+	ConsulAddr     string `long:"consul-addr" env:"CONSUL_ADDR" description:"consul agent address, used when --registry=consul" default:"127.0.0.1:8500"`
+	DefinitionFile string `long:"definition-file" env:"DESOTO_DEFINITION_FILE" description:"path to a TOML file of service definitions, used when --registry=file"`
+
+	LabelDiscovery bool   `long:"label-discovery" env:"DESOTO_LABEL_DISCOVERY" description:"discover services from docker container labels instead of pre-registered service definitions"`
+	LabelPrefix    string `long:"label-prefix" env:"DESOTO_LABEL_PREFIX" description:"label prefix used for label-based service discovery" default:"desoto"`
+
+	ManageFrontends    bool     `long:"manage-frontends" env:"DESOTO_MANAGE_FRONTENDS" description:"also write vulcand frontend (route) entries for each service"`
+	FrontendMiddleware []string `long:"frontend-middleware" env:"DESOTO_FRONTEND_MIDDLEWARE" description:"vulcand middleware type(s) to attach to every generated frontend, in order"`
+
+	ManageTLS   bool   `long:"manage-tls" env:"DESOTO_MANAGE_TLS" description:"write a TLS certificate for the external hostname into its vulcand host entry; no listener entry is written (requires --manage-frontends)"`
+	TLSCertFile string `long:"tls-cert-file" env:"DESOTO_TLS_CERT_FILE" description:"PEM-encoded certificate file, used when --manage-tls"`
+	TLSKeyFile  string `long:"tls-key-file" env:"DESOTO_TLS_KEY_FILE" description:"PEM-encoded private key file, used when --manage-tls"`
 }
 
 func init() {
@@ -36,43 +55,113 @@ func main() {
 		log.Fatal("could not parse command line arguments")
 	}
 
+	if opts.ManageTLS && !opts.ManageFrontends {
+		log.Fatal("--manage-tls requires --manage-frontends")
+	}
+
 	go func() {
 		log.Info(http.ListenAndServe("0.0.0.0:6060", nil))
 	}()
 
 	log.WithField("hosts", opts.EtcdHosts).Info("connecting to etcd")
 	etcdClient := etcd.NewClient(opts.EtcdHosts)
-	etcdClient.CreateDir(opts.ServiceDefinitionBase, 0)
 
 	log.WithField("host", opts.DockerPath).Info("connecting to docker")
 	dockerClient := mustGetDockerClient(opts.DockerPath)
-	_ = dockerClient
+
+	log.WithField("registry", opts.Registry).Info("setting up service registry")
+	registry, err := newServiceRegistry(opts.Registry, opts.ServiceDefinitionBase, opts.VulcandEtcdBase, opts.ConsulAddr, opts.DefinitionFile, etcdClient)
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to set up service registry")
+	}
+
+	var elector *LeaderElector
+	if opts.HA {
+		nodeID := opts.NodeID
+		if nodeID == "" {
+			if hostname, err := os.Hostname(); err == nil {
+				nodeID = hostname
+			} else {
+				nodeID = opts.Host
+			}
+		}
+		elector = newLeaderElector(etcdClient, nodeID)
+		elector.tryAcquireOrRenew()
+		go elector.run()
+		http.HandleFunc("/status", elector.statusHandler)
+		log.WithField("node_id", nodeID).Info("ha mode enabled, participating in leader election")
+	}
+	isLeader := func() bool {
+		return elector == nil || elector.IsLeader()
+	}
+
+	if opts.ManageTLS && isLeader() {
+		if err := registry.PutTLS(opts.Host, opts.TLSCertFile, opts.TLSKeyFile); err != nil {
+			log.WithField("error", err).Fatal("unable to write TLS listener configuration")
+		}
+	}
 
 	log.Info("setting up backends")
-	svcs := mustGetServices(etcdClient, &opts.ServiceDefinitionBase)
+	svcs := mustListServices(registry)
 	log.WithField("count", len(svcs)).Debug("found service definitions")
-	initializeVulcandBackends(etcdClient, opts.VulcandEtcdBase, svcs)
-	log.Info("initial pass")
-	updateVulcanDFromDocker(dockerClient, etcdClient, &opts.VulcandEtcdBase, svcs)
+	if isLeader() {
+		initializeVulcandBackends(registry, svcs)
+		if opts.ManageFrontends {
+			initializeVulcandFrontends(registry, svcs)
+		}
+		log.Info("initial pass")
+		updateVulcanDFromDocker(dockerClient, registry, svcs)
+	}
 
+	// reconciliation safety net: the event listener below handles the common
+	// case in near-real-time, but the ticker still sweeps periodically in
+	// case an event is dropped or the listener reconnects mid-stream
 	ticker := time.NewTicker(30 * time.Second)
 
-	defChange := make(chan bool)
-	mustWatchServiceDefs(etcdClient, &opts.ServiceDefinitionBase, defChange)
+	defChange := registry.Watch()
+
+	// buffered: go-dockerclient's dispatcher sends to each listener
+	// non-blockingly and drops the event on a full/unready channel, so an
+	// unbuffered channel would silently lose events during a churn burst or
+	// while handleContainerEvent/updateVulcanDFromDocker is still running.
+	dockerEvents := make(chan *docker.APIEvents, 100)
+	if err := dockerClient.AddEventListener(dockerEvents); err != nil {
+		log.WithField("error", err).Fatal("unable to subscribe to docker events")
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Info("beginning watch")
-	// NOTE: never deletes backends, so orphans will need to be removed manually
 	for {
 		select {
+		case sig := <-sigCh:
+			log.WithField("signal", sig).Info("shutting down")
+			ticker.Stop()
+			if isLeader() {
+				reapOrphans(dockerClient, registry, svcs)
+			}
+			return
 		case <-defChange:
 			log.Info("detected change to service definitions")
-			svcs = mustGetServices(etcdClient, &opts.ServiceDefinitionBase)
+			svcs = mustListServices(registry)
 			log.WithField("count", len(svcs)).Debug("found service definitions")
-			initializeVulcandBackends(etcdClient, opts.VulcandEtcdBase, svcs)
-			updateVulcanDFromDocker(dockerClient, etcdClient, &opts.VulcandEtcdBase, svcs)
+			if isLeader() {
+				initializeVulcandBackends(registry, svcs)
+				if opts.ManageFrontends {
+					initializeVulcandFrontends(registry, svcs)
+				}
+				updateVulcanDFromDocker(dockerClient, registry, svcs)
+			}
+		case event := <-dockerEvents:
+			if isLeader() {
+				handleContainerEvent(dockerClient, registry, svcs, event)
+			}
 		case <-ticker.C:
 			log.Debug("tick")
-			updateVulcanDFromDocker(dockerClient, etcdClient, &opts.VulcandEtcdBase, svcs)
+			if isLeader() {
+				updateVulcanDFromDocker(dockerClient, registry, svcs)
+			}
 		}
 	}
 }
@@ -89,19 +178,24 @@ func mustGetDockerClient(path string) *docker.Client {
 	return client
 }
 
-func updateVulcanDFromDocker(dclient *docker.Client, eclient *etcd.Client, vulcanPath *string, svcs services) {
+func updateVulcanDFromDocker(dclient *docker.Client, registry ServiceRegistry, svcs services) {
 	containers, err := dclient.ListContainers(docker.ListContainersOptions{})
 	if err != nil {
 		log.WithField("error", err).Fatal("unable to list running docker containers")
 	}
 
 	for _, c := range containers {
+		if opts.LabelDiscovery {
+			registerLabeledContainer(registry, opts.LabelPrefix, &c)
+			continue
+		}
+
 		for _, name := range c.Names {
 			cleanName := strings.TrimLeft(name, "/")
 			for _, s := range svcs {
 				if s.re.MatchString(cleanName) {
 					log.WithField("container_name", cleanName).Debug("registering container as server")
-					registerContainerWithVulcan(eclient, s, &c, vulcanPath, cleanName)
+					registerContainerWithVulcan(registry, s, &c, cleanName)
 				}
 			}
 		}
@@ -109,7 +203,7 @@ func updateVulcanDFromDocker(dclient *docker.Client, eclient *etcd.Client, vulca
 
 }
 
-func registerContainerWithVulcan(client *etcd.Client, svc *Service, container *docker.APIContainers, vulcanPath *string, instanceName string) {
+func registerContainerWithVulcan(registry ServiceRegistry, svc *Service, container *docker.APIContainers, instanceName string) {
 	port, err := findExternalPort(container, svc.serviceDef.ContainerPort)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -123,7 +217,7 @@ func registerContainerWithVulcan(client *etcd.Client, svc *Service, container *d
 	}
 
 	server := newServer(opts.Host, port)
-	if err = server.put(client, *vulcanPath, svc.key, instanceName); err != nil {
+	if err = registry.PutServer(svc, instanceName, server, serverTTL); err != nil {
 		log.WithFields(log.Fields{
 			"error":          err,
 			"service":        svc.key,
@@ -149,10 +243,9 @@ func findExternalPort(container *docker.APIContainers, containerPort int64) (int
 	return -1, PortNotExposedError
 }
 
-func initializeVulcandBackends(client *etcd.Client, basepath string, svcs services) {
+func initializeVulcandBackends(registry ServiceRegistry, svcs services) {
 	for _, s := range svcs {
-		backend := Backend{Type: "http"}
-		if err := backend.put(client, basepath, s.key); err != nil {
+		if err := registry.PutBackend(s); err != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
 				"service": s.key,
@@ -161,62 +254,21 @@ func initializeVulcandBackends(client *etcd.Client, basepath string, svcs servic
 	}
 }
 
-// non-blocking
-func mustWatchServiceDefs(client *etcd.Client, basepath *string, changed chan<- bool) {
-	receiver := make(chan *etcd.Response)
-	go func() {
-		for {
-			<-receiver
-			changed <- true
-		}
-	}()
-
-	watchOperation := func() error {
-		_, err := client.Watch(*basepath, 0, true, receiver, nil)
-		return err
-	}
-
-	errNotify := func(nerr error, dur time.Duration) {
-		log.WithFields(log.Fields{
-			"error":       nerr,
-			"servicepath": *basepath,
-			"duration":    dur,
-		}).Warn("etcd watch failed")
-	}
-
-	go func() {
-		err := backoff.RetryNotify(watchOperation, backoff.NewExponentialBackOff(), errNotify)
-		if err != nil {
+func initializeVulcandFrontends(registry ServiceRegistry, svcs services) {
+	for _, s := range svcs {
+		if err := registry.PutFrontend(s, opts.FrontendMiddleware); err != nil {
 			log.WithFields(log.Fields{
-				"error":       err,
-				"servicepath": *basepath,
-			}).Fatal("could not recover communications with etcd, watch failed")
+				"error":   err,
+				"service": s.key,
+			}).Warn("could not register frontend")
 		}
-	}()
+	}
 }
 
-func mustGetServices(client *etcd.Client, basepath *string) services {
-	resp, err := client.Get(*basepath, false, true)
+func mustListServices(registry ServiceRegistry) services {
+	svcs, err := registry.List()
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error":    err,
-			"basepath": *basepath,
-		}).Fatal("unable to get service definitions from etcd")
-	}
-
-	var svcs services
-	for _, node := range resp.Node.Nodes {
-		s, err := newService(node.Key, []byte(node.Value))
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error":    err,
-				"basepath": *basepath,
-				"key":      node.Key,
-			}).Warn("invalid service definition. skipping.")
-		} else {
-			svcs = append(svcs, s)
-		}
+		log.WithField("error", err).Fatal("unable to get service definitions from registry")
 	}
-
 	return svcs
 }
@@ -0,0 +1,78 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"fmt"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// EventType describes the kind of change a ServiceRegistry observed.
+type EventType int
+
+const (
+	// EventServiceDefsChanged indicates the caller should reload the
+	// service definition list via List().
+	EventServiceDefsChanged EventType = iota
+)
+
+// Event is emitted on a ServiceRegistry's Watch channel when the service
+// definitions it is responsible for have changed upstream.
+type Event struct {
+	Type EventType
+}
+
+// ServiceRegistry abstracts the backend desoto reads service definitions
+// from and writes the resulting vulcand backend/server entries to. This
+// lets desoto run against etcd (the original, and vulcand's own store),
+// Consul, or a static definitions file without the rest of the program
+// caring which one is in play.
+type ServiceRegistry interface {
+	// List returns the current set of service definitions.
+	List() (services, error)
+	// Watch returns a channel that receives an Event whenever the service
+	// definitions change. Setting up the watch is non-blocking.
+	Watch() <-chan Event
+	// PutBackend registers a vulcand backend for the given service.
+	PutBackend(svc *Service) error
+	// DeleteBackend removes a backend and its server entries, e.g. when a
+	// label-discovered service's last container instance is gone.
+	DeleteBackend(svcKey string) error
+	// ListBackends returns the keys of every backend currently registered.
+	ListBackends() ([]string, error)
+	// PutServer registers a container instance as a server for the given
+	// service, with the given TTL (0 means no expiry). The entry expires on
+	// its own if this instance stops refreshing it.
+	PutServer(svc *Service, instanceName string, server *Server, ttl uint64) error
+	// ListServers returns the instance names currently registered for a service.
+	ListServers(svcKey string) ([]string, error)
+	// DeleteServer removes a server entry, e.g. when its container has vanished.
+	DeleteServer(svcKey string, instanceName string) error
+	// PutFrontend registers a vulcand frontend routing a host/path pair to
+	// the given service's backend, wrapped with the named middleware chain.
+	PutFrontend(svc *Service, middlewares []string) error
+	// DeleteFrontend removes a frontend and its middleware entries, e.g.
+	// when a label-discovered service's last container instance is gone.
+	DeleteFrontend(svcKey string) error
+	// PutTLS writes the certificate pair for the given host into its vulcand
+	// host entry. It does not write a listener entry.
+	PutTLS(host, certFile, keyFile string) error
+}
+
+// newServiceRegistry builds the ServiceRegistry selected by --registry.
+// Non-etcd backends still write vulcand entries to etcd, since vulcand
+// itself only ever reads its routing table from there - only the source of
+// service definitions changes.
+func newServiceRegistry(kind, servicedefBase, vulcanBase, consulAddr, definitionFile string, etcdClient *etcd.Client) (ServiceRegistry, error) {
+	switch kind {
+	case "etcd":
+		return newEtcdRegistry(etcdClient, servicedefBase, vulcanBase), nil
+	case "consul":
+		return newConsulRegistry(consulAddr, servicedefBase, etcdClient, vulcanBase)
+	case "file":
+		if definitionFile == "" {
+			return nil, fmt.Errorf("--definition-file is required when --registry=file")
+		}
+		return newFileRegistry(definitionFile, etcdClient, vulcanBase), nil
+	default:
+		return nil, fmt.Errorf("unknown registry backend %q", kind)
+	}
+}
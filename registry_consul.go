@@ -0,0 +1,80 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/hashicorp/consul/api"
+	"time"
+)
+
+// consulRegistry sources service definitions from a Consul KV prefix
+// instead of etcd's /publication tree, for operators who already run
+// Consul-based service discovery. Backend/server writes still go to etcd,
+// see vulcanWriter.
+type consulRegistry struct {
+	*vulcanWriter
+	kv     *api.KV
+	prefix string
+}
+
+func newConsulRegistry(addr, prefix string, etcdClient *etcd.Client, vulcanBase string) (*consulRegistry, error) {
+	client, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		return nil, err
+	}
+
+	return &consulRegistry{
+		vulcanWriter: &vulcanWriter{client: etcdClient, base: vulcanBase},
+		kv:           client.KV(),
+		prefix:       prefix,
+	}, nil
+}
+
+func (r *consulRegistry) List() (services, error) {
+	pairs, _, err := r.kv.List(r.prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var svcs services
+	for _, pair := range pairs {
+		s, err := newService(pair.Key, pair.Value)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"key":   pair.Key,
+			}).Warn("invalid service definition. skipping.")
+			continue
+		}
+		svcs = append(svcs, s)
+	}
+
+	return svcs, nil
+}
+
+// Watch polls Consul's blocking query API for changes under the prefix.
+func (r *consulRegistry) Watch() <-chan Event {
+	changed := make(chan Event)
+
+	go func() {
+		var lastIndex uint64
+		for {
+			_, meta, err := r.kv.List(r.prefix, &api.QueryOptions{WaitIndex: lastIndex})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"prefix": r.prefix,
+				}).Warn("consul watch failed, retrying")
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			if lastIndex != 0 && meta.LastIndex != lastIndex {
+				changed <- Event{Type: EventServiceDefsChanged}
+			}
+			lastIndex = meta.LastIndex
+		}
+	}()
+
+	return changed
+}
@@ -0,0 +1,85 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+	"github.com/coreos/go-etcd/etcd"
+	"time"
+)
+
+// etcdRegistry is the original ServiceRegistry implementation: service
+// definitions live under servicedefBase in etcd, alongside the vulcand
+// tree itself.
+type etcdRegistry struct {
+	*vulcanWriter
+	client         *etcd.Client
+	servicedefBase string
+}
+
+func newEtcdRegistry(client *etcd.Client, servicedefBase, vulcanBase string) *etcdRegistry {
+	client.CreateDir(servicedefBase, 0)
+	return &etcdRegistry{
+		vulcanWriter:   &vulcanWriter{client: client, base: vulcanBase},
+		client:         client,
+		servicedefBase: servicedefBase,
+	}
+}
+
+func (r *etcdRegistry) List() (services, error) {
+	resp, err := r.client.Get(r.servicedefBase, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var svcs services
+	for _, node := range resp.Node.Nodes {
+		s, err := newService(node.Key, []byte(node.Value))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"basepath": r.servicedefBase,
+				"key":      node.Key,
+			}).Warn("invalid service definition. skipping.")
+			continue
+		}
+		svcs = append(svcs, s)
+	}
+
+	return svcs, nil
+}
+
+// Watch is non-blocking.
+func (r *etcdRegistry) Watch() <-chan Event {
+	changed := make(chan Event)
+	receiver := make(chan *etcd.Response)
+	go func() {
+		for range receiver {
+			changed <- Event{Type: EventServiceDefsChanged}
+		}
+	}()
+
+	watchOperation := func() error {
+		_, err := r.client.Watch(r.servicedefBase, 0, true, receiver, nil)
+		return err
+	}
+
+	errNotify := func(nerr error, dur time.Duration) {
+		log.WithFields(log.Fields{
+			"error":       nerr,
+			"servicepath": r.servicedefBase,
+			"duration":    dur,
+		}).Warn("etcd watch failed")
+	}
+
+	go func() {
+		err := backoff.RetryNotify(watchOperation, backoff.NewExponentialBackOff(), errNotify)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":       err,
+				"servicepath": r.servicedefBase,
+			}).Fatal("could not recover communications with etcd, watch failed")
+		}
+	}()
+
+	return changed
+}
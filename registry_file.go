@@ -0,0 +1,87 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"github.com/BurntSushi/toml"
+	log "github.com/Sirupsen/logrus"
+	"github.com/coreos/go-etcd/etcd"
+	"gopkg.in/fsnotify.v1"
+	"path/filepath"
+)
+
+// fileRegistry sources service definitions from a static TOML file instead
+// of a remote store, for local development without an etcd (or Consul)
+// instance running. Each top-level table under [services] is decoded the
+// same way an etcd service definition value would be. Backend/server
+// writes still go to etcd, see vulcanWriter.
+type fileRegistry struct {
+	*vulcanWriter
+	path string
+}
+
+func newFileRegistry(path string, etcdClient *etcd.Client, vulcanBase string) *fileRegistry {
+	return &fileRegistry{
+		vulcanWriter: &vulcanWriter{client: etcdClient, base: vulcanBase},
+		path:         path,
+	}
+}
+
+type fileDefinitions struct {
+	Services map[string]map[string]interface{} `toml:"services"`
+}
+
+func (r *fileRegistry) List() (services, error) {
+	var defs fileDefinitions
+	if _, err := toml.DecodeFile(r.path, &defs); err != nil {
+		return nil, err
+	}
+
+	var svcs services
+	for key, def := range defs.Services {
+		raw, err := json.Marshal(def)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"key":   key,
+			}).Warn("invalid service definition. skipping.")
+			continue
+		}
+
+		s, err := newService("/"+key, raw)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"key":   key,
+			}).Warn("invalid service definition. skipping.")
+			continue
+		}
+		svcs = append(svcs, s)
+	}
+
+	return svcs, nil
+}
+
+// Watch is non-blocking.
+func (r *fileRegistry) Watch() <-chan Event {
+	changed := make(chan Event)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithField("error", err).Fatal("unable to start file watcher for service definitions")
+	}
+
+	if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+		log.WithField("error", err).Fatal("unable to watch service definitions directory")
+	}
+
+	go func() {
+		for event := range watcher.Events {
+			if event.Name == r.path {
+				log.WithField("path", r.path).Debug("service definition file changed")
+				changed <- Event{Type: EventServiceDefsChanged}
+			}
+		}
+	}()
+
+	return changed
+}
@@ -0,0 +1,50 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileRegistryList(t *testing.T) {
+	f, err := ioutil.TempFile("", "desoto-servicedefs")
+	if err != nil {
+		t.Fatalf("unable to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	const defs = `
+[services.web]
+Name = "^web-\\d+$"
+ContainerPort = 8080
+`
+	if _, err := f.WriteString(defs); err != nil {
+		t.Fatalf("unable to write temp file: %v", err)
+	}
+	f.Close()
+
+	registry := newFileRegistry(f.Name(), nil, "/vulcand")
+	svcs, err := registry.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(svcs) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(svcs))
+	}
+
+	if svcs[0].key != "web" {
+		t.Fatalf("expected service key %q, got %q", "web", svcs[0].key)
+	}
+
+	if !svcs[0].re.MatchString("web-1") {
+		t.Fatal("expected compiled regex to match \"web-1\"")
+	}
+}
+
+func TestFileRegistryListMissingFile(t *testing.T) {
+	registry := newFileRegistry("/nonexistent/path/to/servicedefs.toml", nil, "/vulcand")
+	if _, err := registry.List(); err == nil {
+		t.Fatal("expected an error for a missing definitions file")
+	}
+}
@@ -0,0 +1,39 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import "testing"
+
+func TestNewServiceRegistryUnknownBackend(t *testing.T) {
+	_, err := newServiceRegistry("bogus", "/publication", "/vulcand", "", "", nil)
+	if err == nil {
+		t.Fatal("expected an error for an unknown registry backend")
+	}
+}
+
+func TestNewServiceRegistryFileRequiresDefinitionFile(t *testing.T) {
+	_, err := newServiceRegistry("file", "/publication", "/vulcand", "", "", nil)
+	if err == nil {
+		t.Fatal("expected an error when --definition-file is missing")
+	}
+}
+
+func TestNewServiceRegistryFile(t *testing.T) {
+	registry, err := newServiceRegistry("file", "/publication", "/vulcand", "", "services.toml", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := registry.(*fileRegistry); !ok {
+		t.Fatalf("expected a *fileRegistry, got %T", registry)
+	}
+}
+
+func TestNewServiceRegistryConsul(t *testing.T) {
+	registry, err := newServiceRegistry("consul", "/publication", "/vulcand", "127.0.0.1:8500", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := registry.(*consulRegistry); !ok {
+		t.Fatalf("expected a *consulRegistry, got %T", registry)
+	}
+}
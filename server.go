@@ -0,0 +1,29 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coreos/go-etcd/etcd"
+	"strings"
+)
+
+// Server mirrors a vulcand server entry: a single backend instance's URL.
+type Server struct {
+	URL string `json:"URL"`
+}
+
+func newServer(host string, port int64) *Server {
+	return &Server{URL: fmt.Sprintf("http://%s:%d", host, port)}
+}
+
+// put writes the server entry with the given TTL (0 means no expiry).
+func (s *Server) put(client *etcd.Client, basepath string, svcKey string, instanceName string, ttl uint64) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Join([]string{basepath, "backends", svcKey, "servers", instanceName}, "/")
+	_, err = client.Set(key, string(body), ttl)
+	return err
+}
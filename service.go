@@ -0,0 +1,67 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// serviceDef is the JSON body stored for a service definition, keyed by
+// name under ServiceDefinitionBase (or its equivalent in a non-etcd
+// registry). Name is a regex matched against running container names.
+type serviceDef struct {
+	Name          string   `json:"Name"`
+	ContainerPort int64    `json:"ContainerPort"`
+	Middleware    []string `json:"Middleware,omitempty"`
+	Methods       []string `json:"Methods,omitempty"`
+}
+
+// Service pairs a parsed service definition with the compiled regex used
+// to match container names against it.
+type Service struct {
+	key        string
+	serviceDef serviceDef
+	re         *regexp.Regexp
+}
+
+// middleware is the per-service vulcand middleware chain (e.g. "auth",
+// "rewrite"), if any was declared on the service definition.
+func (s *Service) middleware() []string {
+	return s.serviceDef.Middleware
+}
+
+// methods is the set of HTTP methods the service's frontend should match,
+// if any were declared. An empty set matches any method.
+func (s *Service) methods() []string {
+	return s.serviceDef.Methods
+}
+
+type services []*Service
+
+// ErrMissingServiceName is returned by newService when a definition has no
+// Name pattern to compile.
+var ErrMissingServiceName = errors.New("service definition is missing a Name pattern")
+
+// newService parses a service definition's JSON body and compiles its name
+// pattern. key is a slash-separated path (as used by etcd/Consul); the
+// service's key is its last path segment.
+func newService(key string, value []byte) (*Service, error) {
+	var def serviceDef
+	if err := json.Unmarshal(value, &def); err != nil {
+		return nil, err
+	}
+
+	if def.Name == "" {
+		return nil, ErrMissingServiceName
+	}
+
+	re, err := regexp.Compile(def.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+
+	return &Service{key: parts[len(parts)-1], serviceDef: def, re: re}, nil
+}
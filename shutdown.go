@@ -0,0 +1,88 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"strings"
+)
+
+// serverTTL bounds how long a server entry survives in vulcand without a
+// refresh. It's set well above the reconciliation tick interval so a
+// single missed tick doesn't cause flapping, but a crashed desoto instance
+// simply stops refreshing and its entries expire on their own.
+const serverTTL = uint64(90)
+
+// reapOrphans deletes any server entry belonging to a known service whose
+// instance name no longer corresponds to a container running on this host.
+// It's run once on graceful shutdown, in addition to the per-server TTL
+// that covers the crash case.
+func reapOrphans(dclient *docker.Client, registry ServiceRegistry, svcs services) {
+	containers, err := dclient.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		log.WithField("error", err).Warn("unable to list running containers, skipping orphan sweep")
+		return
+	}
+
+	running := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		for _, name := range c.Names {
+			running[strings.TrimLeft(name, "/")] = true
+		}
+	}
+
+	known := make(map[string]bool, len(svcs))
+	for _, s := range svcs {
+		known[s.key] = true
+		reapOrphanedServers(registry, s.key, running)
+	}
+
+	// label-discovered services never appear in svcs (they're synthesized
+	// per-container, not pre-registered), so sweep every backend vulcand
+	// actually knows about and reap anything this loop didn't already cover.
+	if opts.LabelDiscovery {
+		backendKeys, err := registry.ListBackends()
+		if err != nil {
+			log.WithField("error", err).Warn("unable to list backends for label-discovery orphan sweep")
+			return
+		}
+
+		for _, key := range backendKeys {
+			if known[key] {
+				continue
+			}
+			reapOrphanedServers(registry, key, running)
+			reapLabeledService(registry, key)
+		}
+	}
+}
+
+// reapOrphanedServers removes a service's server entries whose instance
+// name no longer corresponds to a running container.
+func reapOrphanedServers(registry ServiceRegistry, svcKey string, running map[string]bool) {
+	instances, err := registry.ListServers(svcKey)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"service": svcKey,
+		}).Warn("unable to list servers for orphan sweep")
+		return
+	}
+
+	for _, instanceName := range instances {
+		if running[instanceName] {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"service":        svcKey,
+			"container_name": instanceName,
+		}).Info("reaping orphaned server entry")
+		if err := registry.DeleteServer(svcKey, instanceName); err != nil {
+			log.WithFields(log.Fields{
+				"error":          err,
+				"service":        svcKey,
+				"container_name": instanceName,
+			}).Warn("could not reap orphaned server")
+		}
+	}
+}
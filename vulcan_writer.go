@@ -0,0 +1,131 @@
+package main // import "github.com/christian-blades-cb/desoto"
+
+import (
+	"fmt"
+	"github.com/coreos/go-etcd/etcd"
+	"io/ioutil"
+	"strings"
+)
+
+// vulcanWriter writes backend/server entries into vulcand's etcd tree.
+// Every ServiceRegistry implementation embeds one, since vulcand only ever
+// reads its routing table from etcd regardless of where the service
+// definitions it's fronting came from.
+type vulcanWriter struct {
+	client *etcd.Client
+	base   string
+}
+
+func (w *vulcanWriter) PutBackend(svc *Service) error {
+	backend := Backend{Type: "http"}
+	return backend.put(w.client, w.base, svc.key)
+}
+
+// DeleteBackend removes a backend and every server entry nested under it.
+func (w *vulcanWriter) DeleteBackend(svcKey string) error {
+	key := strings.Join([]string{w.base, "backends", svcKey}, "/")
+	_, err := w.client.Delete(key, true)
+	return err
+}
+
+// ListBackends returns the keys of every backend currently registered in
+// vulcand's etcd tree, e.g. for garbage-collecting label-discovered
+// backends that no longer correspond to any running container.
+func (w *vulcanWriter) ListBackends() ([]string, error) {
+	key := strings.Join([]string{w.base, "backends"}, "/")
+	resp, err := w.client.Get(key, false, true)
+	if err != nil {
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == etcd.ErrorCodeKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		parts := strings.Split(node.Key, "/")
+		keys = append(keys, parts[len(parts)-1])
+	}
+	return keys, nil
+}
+
+func (w *vulcanWriter) PutServer(svc *Service, instanceName string, server *Server, ttl uint64) error {
+	return server.put(w.client, w.base, svc.key, instanceName, ttl)
+}
+
+func (w *vulcanWriter) DeleteServer(svcKey string, instanceName string) error {
+	key := strings.Join([]string{w.base, "backends", svcKey, "servers", instanceName}, "/")
+	_, err := w.client.Delete(key, false)
+	return err
+}
+
+func (w *vulcanWriter) ListServers(svcKey string) ([]string, error) {
+	key := strings.Join([]string{w.base, "backends", svcKey, "servers"}, "/")
+	resp, err := w.client.Get(key, false, true)
+	if err != nil {
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == etcd.ErrorCodeKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		parts := strings.Split(node.Key, "/")
+		names = append(names, parts[len(parts)-1])
+	}
+	return names, nil
+}
+
+func (w *vulcanWriter) PutFrontend(svc *Service, middlewares []string) error {
+	frontend := &Frontend{
+		Type:      "http",
+		BackendId: svc.key,
+		Route:     frontendRoute(opts.Host, svc.key, svc.methods()),
+	}
+	if err := frontend.put(w.client, w.base, svc.key); err != nil {
+		return err
+	}
+
+	// a service's own middleware (e.g. from a label) takes precedence over
+	// the global --frontend-middleware chain, rather than stacking both.
+	if svcMiddleware := svc.middleware(); len(svcMiddleware) > 0 {
+		middlewares = svcMiddleware
+	}
+
+	for i, mwType := range middlewares {
+		mw := &Middleware{
+			Type:     mwType,
+			ID:       fmt.Sprintf("%s-%d", mwType, i),
+			Priority: i,
+		}
+		if err := mw.put(w.client, w.base, svc.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteFrontend removes a frontend and every middleware entry nested
+// under it.
+func (w *vulcanWriter) DeleteFrontend(svcKey string) error {
+	key := strings.Join([]string{w.base, "frontends", svcKey}, "/")
+	_, err := w.client.Delete(key, true)
+	return err
+}
+
+func (w *vulcanWriter) PutTLS(host, certFile, keyFile string) error {
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+
+	hostCfg := HostSettings{KeyPair: &KeyPair{Cert: cert, Key: key}}
+	return hostCfg.put(w.client, w.base, host)
+}